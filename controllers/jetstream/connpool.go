@@ -0,0 +1,212 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+const defaultReconnectWait = 2 * time.Second
+
+// natsConnPool hands out long-lived *nats.Conn handles keyed by the identity
+// of the server set + credentials a Stream/KeyValueBucket/ObjectStore CR
+// connects with, so reconciles stop paying per-call TCP/TLS/handshake
+// cost. Reconnect/disconnect/close events re-enqueue every CR that was handed
+// a connection for that key, so reconcilers never have to poll for server
+// rolling upgrades.
+type natsConnPool struct {
+	mu    sync.Mutex
+	conns map[string]*nats.Conn
+	deps  map[string][]connDependent
+	// itemKeys tracks the connKey each CR (by its queue and workqueue item
+	// key) last registered against, so Register can detect when a CR's
+	// identity moved to a new key (e.g. a Secret rotation) and evict the
+	// now-unused pooled connection for its old key instead of leaving it
+	// dialed forever.
+	itemKeys map[connDependent]string
+	// dialing holds one channel per connKey currently being dialed, so
+	// concurrent Get calls racing on the same new key (plausible at startup,
+	// since the Stream/KVBucket/ObjectStore queues reconcile independently)
+	// wait for the in-flight dial instead of each dialing their own
+	// connection and leaking all but the last one written to conns.
+	dialing map[string]chan struct{}
+}
+
+// connDependent is a CR's workqueue key, re-enqueued whenever its connection
+// disconnects, reconnects, or closes for good.
+type connDependent struct {
+	queue workqueue.RateLimitingInterface
+	key   string
+}
+
+func newNATSConnPool() *natsConnPool {
+	return &natsConnPool{
+		conns:    make(map[string]*nats.Conn),
+		deps:     make(map[string][]connDependent),
+		itemKeys: make(map[connDependent]string),
+		dialing:  make(map[string]chan struct{}),
+	}
+}
+
+// connKey identifies a connection by its sorted server list plus whatever
+// credentials/NKey/JWT/TLS identity the CR authenticates with, so two CRs
+// that point at the same cluster with the same identity share one *nats.Conn.
+func connKey(servers []string, identity string) string {
+	sorted := append([]string(nil), servers...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",") + "|" + identity
+}
+
+// Register records that q's item key depends on the connection for connKey,
+// so a reconnect/close event on that connection re-enqueues it. If itemKey
+// previously depended on a different connKey (its CR's identity rotated,
+// e.g. a Secret it authenticates with was renewed), the stale registration
+// is dropped and, once nothing else references that old connection, it's
+// closed and evicted so the next Get for it dials fresh with new credentials
+// rather than quietly handing back a connection opened under the old ones.
+func (p *natsConnPool) Register(key string, q workqueue.RateLimitingInterface, itemKey string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dep := connDependent{queue: q, key: itemKey}
+	if prevKey, ok := p.itemKeys[dep]; ok && prevKey != key {
+		p.removeDependentLocked(prevKey, q, itemKey)
+	}
+	p.itemKeys[dep] = key
+
+	for _, d := range p.deps[key] {
+		if d.queue == q && d.key == itemKey {
+			return
+		}
+	}
+	p.deps[key] = append(p.deps[key], connDependent{queue: q, key: itemKey})
+}
+
+// removeDependentLocked drops itemKey's dependency on key and, if key has no
+// remaining dependents, closes and evicts its pooled connection. p.mu must
+// already be held.
+func (p *natsConnPool) removeDependentLocked(key string, q workqueue.RateLimitingInterface, itemKey string) {
+	deps := p.deps[key][:0]
+	for _, d := range p.deps[key] {
+		if d.queue == q && d.key == itemKey {
+			continue
+		}
+		deps = append(deps, d)
+	}
+
+	if len(deps) > 0 {
+		p.deps[key] = deps
+		return
+	}
+
+	delete(p.deps, key)
+	if nc, ok := p.conns[key]; ok {
+		nc.Close()
+		delete(p.conns, key)
+	}
+}
+
+// Get returns the pooled connection for key, dialing and caching a new one
+// with unlimited reconnects if none exists yet. Concurrent Get calls for a
+// key with no connection yet wait for a single dial rather than each
+// connecting independently: the loser's *nats.Conn would otherwise never be
+// closed, since only the winner's write to conns is ever seen again.
+func (p *natsConnPool) Get(key, servers string, opts ...nats.Option) (*nats.Conn, error) {
+	for {
+		p.mu.Lock()
+		if nc, ok := p.conns[key]; ok && !nc.IsClosed() {
+			p.mu.Unlock()
+			return nc, nil
+		}
+
+		if wait, ok := p.dialing[key]; ok {
+			p.mu.Unlock()
+			<-wait
+			continue
+		}
+
+		done := make(chan struct{})
+		p.dialing[key] = done
+		p.mu.Unlock()
+
+		nc, err := p.dial(key, servers, opts...)
+
+		p.mu.Lock()
+		delete(p.dialing, key)
+		if err == nil {
+			p.conns[key] = nc
+		}
+		p.mu.Unlock()
+		close(done)
+
+		return nc, err
+	}
+}
+
+// dial connects to servers under key's reconnect/close handlers. It must
+// only ever be called by the single Get goroutine holding key's dialing slot.
+func (p *natsConnPool) dial(key, servers string, opts ...nats.Option) (*nats.Conn, error) {
+	opts = append(append([]nats.Option{}, opts...),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(defaultReconnectWait),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			p.requeueDependents(key)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			p.requeueDependents(key)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			p.mu.Lock()
+			delete(p.conns, key)
+			p.mu.Unlock()
+			p.requeueDependents(key)
+		}),
+	)
+
+	nc, err := nats.Connect(servers, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+
+	return nc, nil
+}
+
+func (p *natsConnPool) requeueDependents(key string) {
+	p.mu.Lock()
+	deps := append([]connDependent(nil), p.deps[key]...)
+	p.mu.Unlock()
+
+	for _, d := range deps {
+		d.queue.Add(d.key)
+	}
+}
+
+// Shutdown closes every pooled connection, e.g. on controller shutdown.
+func (p *natsConnPool) Shutdown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, nc := range p.conns {
+		nc.Close()
+		delete(p.conns, key)
+	}
+}
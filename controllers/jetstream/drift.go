@@ -0,0 +1,156 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+	typed "github.com/nats-io/nack/pkg/jetstream/generated/clientset/versioned/typed/jetstream/v1"
+
+	k8sapi "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const streamDriftCondType = "Drifted"
+
+// defaultResyncPeriod caps the resync loop's polling interval (see
+// resyncTick). It is not a fallback period: a Stream with neither a
+// spec.resyncPeriod nor the controller-wide flag set is never drift-checked.
+const defaultResyncPeriod = 5 * time.Minute
+
+// runStreamResyncLoop periodically re-enqueues every known Stream so
+// processStream's drift check runs even when nothing has touched the CR's
+// generation, catching config an admin changed directly on the server (e.g.
+// via `nats stream edit`).
+func (c *Controller) runStreamResyncLoop() {
+	ticker := time.NewTicker(c.resyncTick())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		streams, err := c.streamLister.List(labels.Everything())
+		if err != nil {
+			continue
+		}
+
+		now := time.Now().UTC()
+		for _, stream := range streams {
+			if !c.streamResyncDue(stream, now) {
+				continue
+			}
+			if err := enqueueStreamWork(c.streamQueue, stream); err != nil {
+				continue
+			}
+		}
+	}
+}
+
+// resyncTick is the loop's own polling interval: the shortest period any
+// Stream could plausibly want, so per-CR overrides are never missed by more
+// than one tick.
+func (c *Controller) resyncTick() time.Duration {
+	if c.resyncPeriod > 0 && c.resyncPeriod < defaultResyncPeriod {
+		return c.resyncPeriod
+	}
+	return defaultResyncPeriod
+}
+
+// streamResyncDue reports whether stream is due for a drift check, using its
+// own spec.resyncPeriod override when set and falling back to the
+// controller-wide --resync-period flag otherwise, so streams that don't set
+// a per-CR value still get periodic resync whenever the global flag is on.
+func (c *Controller) streamResyncDue(stream *apis.Stream, now time.Time) bool {
+	period := stream.Spec.ResyncPeriod
+	if period == 0 {
+		period = c.resyncPeriod
+	}
+	if period == 0 {
+		return false
+	}
+
+	last, err := time.Parse(time.RFC3339Nano, stream.Status.LastSyncTime)
+	if err != nil {
+		// Never synced, or status predates this field: due now.
+		return true
+	}
+
+	return now.Sub(last) >= period
+}
+
+// checkStreamDrift fetches the stream's live config from the server and
+// compares it against Spec. If nothing drifted it just bumps LastSyncTime;
+// if the server-side config diverged it either reconciles the difference
+// away or, when PreventDrift is set, reports it via the Drifted condition
+// without touching the server.
+func (c *Controller) checkStreamDrift(stream *apis.Stream, sif typed.StreamInterface) error {
+	if !c.streamResyncDue(stream, time.Now().UTC()) {
+		return nil
+	}
+
+	drifted, diff, err := c.sc.Diff(c.ctx, stream)
+	if err != nil {
+		if _, serr := setStreamErrored(c.ctx, stream, sif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+
+	if !drifted {
+		if _, err := setStreamSynced(c.ctx, stream, sif); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if stream.Spec.PreventDrift {
+		return setStreamDrifted(c.ctx, stream, sif, diff)
+	}
+
+	c.normalEvent(stream, "Reconciling", fmt.Sprintf("Reconciling drifted stream %q: %s", stream.Spec.Name, diff))
+	if err := c.sc.Update(c.ctx, stream); err != nil {
+		if _, serr := setStreamErrored(c.ctx, stream, sif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+
+	_, err = setStreamSynced(c.ctx, stream, sif)
+	return err
+}
+
+func setStreamDrifted(ctx context.Context, s *apis.Stream, sif typed.StreamInterface, diff string) error {
+	sc := s.DeepCopy()
+	sc.Status.LastSyncTime = time.Now().UTC().Format(time.RFC3339Nano)
+	sc.Status.Conditions = upsertStreamCondition(sc.Status.Conditions, apis.StreamCondition{
+		Type:               streamDriftCondType,
+		Status:             k8sapi.ConditionTrue,
+		LastTransitionTime: sc.Status.LastSyncTime,
+		Reason:             "ServerConfigDrifted",
+		Message:            diff,
+	})
+	sc.Status.Conditions = pruneStreamConditions(sc.Status.Conditions)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := sif.UpdateStatus(ctx, sc, k8smeta.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to set %q stream drifted status: %w", s.Spec.Name, err)
+	}
+
+	return nil
+}
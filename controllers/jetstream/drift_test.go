@@ -0,0 +1,81 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"testing"
+	"time"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+)
+
+func TestStreamResyncDue(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name         string
+		resyncPeriod time.Duration
+		stream       *apis.Stream
+		want         bool
+	}{
+		{
+			name: "no per-CR override, no global flag: never due",
+			stream: &apis.Stream{Status: apis.StreamStatus{
+				LastSyncTime: now.Add(-time.Hour).Format(time.RFC3339Nano),
+			}},
+			want: false,
+		},
+		{
+			name:         "no per-CR override, global flag elapsed: due",
+			resyncPeriod: time.Minute,
+			stream: &apis.Stream{Status: apis.StreamStatus{
+				LastSyncTime: now.Add(-time.Hour).Format(time.RFC3339Nano),
+			}},
+			want: true,
+		},
+		{
+			name:         "no per-CR override, global flag not yet elapsed: not due",
+			resyncPeriod: time.Hour,
+			stream: &apis.Stream{Status: apis.StreamStatus{
+				LastSyncTime: now.Add(-time.Minute).Format(time.RFC3339Nano),
+			}},
+			want: false,
+		},
+		{
+			name:         "per-CR override takes precedence over global flag",
+			resyncPeriod: time.Hour,
+			stream: &apis.Stream{
+				Spec:   apis.StreamSpec{ResyncPeriod: time.Minute},
+				Status: apis.StreamStatus{LastSyncTime: now.Add(-10 * time.Minute).Format(time.RFC3339Nano)},
+			},
+			want: true,
+		},
+		{
+			name: "never synced: due regardless of period",
+			stream: &apis.Stream{
+				Spec: apis.StreamSpec{ResyncPeriod: time.Minute},
+			},
+			want: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Controller{resyncPeriod: tc.resyncPeriod}
+			if got := c.streamResyncDue(tc.stream, now); got != tc.want {
+				t.Errorf("streamResyncDue() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
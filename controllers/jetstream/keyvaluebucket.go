@@ -0,0 +1,424 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+	typed "github.com/nats-io/nack/pkg/jetstream/generated/clientset/versioned/typed/jetstream/v1"
+
+	k8sapi "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	kvBucketFinalizerKey  = "keyvaluebucketfinalizer.jetstream.nats.io"
+	kvBucketReadyCondType = "Ready"
+)
+
+func keyValueBucketEventHandlers(ctx context.Context, q workqueue.RateLimitingInterface, jif typed.JetstreamV1Interface) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			kvb, ok := obj.(*apis.KeyValueBucket)
+			if !ok {
+				return
+			}
+
+			if err := enqueueKeyValueBucketWork(q, kvb); err != nil {
+				utilruntime.HandleError(err)
+			}
+		},
+		UpdateFunc: func(prevObj, nextObj interface{}) {
+			prev, ok := prevObj.(*apis.KeyValueBucket)
+			if !ok {
+				return
+			}
+
+			next, ok := nextObj.(*apis.KeyValueBucket)
+			if !ok {
+				return
+			}
+
+			if err := validateKeyValueBucketUpdate(prev, next); errors.Is(err, errNothingToUpdate) {
+				return
+			} else if err != nil {
+				kif := jif.KeyValueBuckets(next.Namespace)
+				if _, serr := setKeyValueBucketErrored(ctx, next, kif, err); serr != nil {
+					err = fmt.Errorf("%s: %w", err, serr)
+				}
+
+				utilruntime.HandleError(err)
+				return
+			}
+
+			if err := enqueueKeyValueBucketWork(q, next); err != nil {
+				utilruntime.HandleError(err)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			kvb, ok := obj.(*apis.KeyValueBucket)
+			if !ok {
+				return
+			}
+
+			if err := enqueueKeyValueBucketWork(q, kvb); err != nil {
+				utilruntime.HandleError(err)
+			}
+		},
+	}
+}
+
+func enqueueKeyValueBucketWork(q workqueue.RateLimitingInterface, kvb *apis.KeyValueBucket) (err error) {
+	key, err := cache.MetaNamespaceKeyFunc(kvb)
+	if err != nil {
+		return fmt.Errorf("failed to queue keyvaluebucket work: %w", err)
+	}
+
+	q.Add(key)
+	return nil
+}
+
+// validateKeyValueBucketUpdate rejects edits to fields the KV API cannot
+// change on an existing bucket. Every other spec change is left to
+// processKeyValueBucket, which pushes the desired KeyValueConfig to the server.
+func validateKeyValueBucketUpdate(prev, next *apis.KeyValueBucket) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("failed to validate update: %w", err)
+		}
+	}()
+
+	if prev.DeletionTimestamp != next.DeletionTimestamp {
+		return nil
+	}
+
+	if prev.Spec.BucketName != next.Spec.BucketName {
+		return fmt.Errorf("updating bucket name is not allowed, please recreate")
+	}
+	if prev.Spec.History != next.Spec.History {
+		return fmt.Errorf("updating bucket history is not allowed, please recreate")
+	}
+	if prev.Spec.Storage != next.Spec.Storage {
+		return fmt.Errorf("updating bucket storage is not allowed, please recreate")
+	}
+
+	if equalKeyValueBucketSpec(prev.Spec, next.Spec) {
+		return errNothingToUpdate
+	}
+
+	return nil
+}
+
+func equalKeyValueBucketSpec(a, b apis.KeyValueBucketSpec) bool {
+	return equality.Semantic.DeepEqual(a, b)
+}
+
+func (c *Controller) runKeyValueBucketQueue() {
+	for {
+		c.processNextKeyValueBucketQueueItem()
+	}
+}
+
+func (c *Controller) processNextKeyValueBucketQueueItem() {
+	item, shutdown := c.kvBucketQueue.Get()
+	if shutdown {
+		return
+	}
+	defer c.kvBucketQueue.Done(item)
+
+	ns, name, err := splitNamespaceName(item)
+	if err != nil {
+		// Probably junk, clean it up.
+		utilruntime.HandleError(err)
+		c.kvBucketQueue.Forget(item)
+		return
+	}
+
+	if c.ha.Sharded && !ownsShard(c.ha.Identity, c.shardReplicas(), ns+"/"+name) {
+		// A different replica owns this CR; drop it without requeueing.
+		c.kvBucketQueue.Forget(item)
+		return
+	}
+
+	err = c.processKeyValueBucket(ns, name)
+	if err == nil {
+		// Item processed successfully, don't requeue.
+		c.kvBucketQueue.Forget(item)
+		return
+	}
+
+	utilruntime.HandleError(err)
+
+	if c.kvBucketQueue.NumRequeues(item) < maxQueueRetries {
+		// Failed to process item, try again.
+		c.kvBucketQueue.AddRateLimited(item)
+		return
+	}
+
+	// If we haven't been able to recover by this point, then just stop.
+	// The user should have enough info in kubectl describe to debug.
+	c.kvBucketQueue.Forget(item)
+}
+
+func (c *Controller) processKeyValueBucket(ns, name string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("failed to process keyvaluebucket: %w", err)
+		}
+	}()
+
+	kvb, err := c.kvBucketLister.KeyValueBuckets(ns).Get(name)
+	if err != nil && k8serrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	kif := c.ji.KeyValueBuckets(kvb.Namespace)
+
+	key, err := cache.MetaNamespaceKeyFunc(kvb)
+	if err != nil {
+		return fmt.Errorf("failed to queue keyvaluebucket work: %w", err)
+	}
+
+	connKey := connKey(kvb.Spec.Servers, "")
+	nc, err := c.connPool.Get(connKey, strings.Join(kvb.Spec.Servers, ","), getNATSOptions(c.natsName)...)
+	if err != nil {
+		if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+	c.connPool.Register(connKey, c.kvBucketQueue, key)
+
+	if err := c.kvc.UseConn(nc); err != nil {
+		if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+
+	deleteOK := kvb.GetDeletionTimestamp() != nil
+	newGeneration := kvb.Generation != kvb.Status.ObservedGeneration
+	bucketExists, err := c.kvc.Exists(c.ctx, kvb.Spec.BucketName)
+	if err != nil {
+		if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+	updateOK := (bucketExists && !deleteOK && newGeneration)
+	createOK := (!bucketExists && !deleteOK && newGeneration)
+
+	switch {
+	case updateOK:
+		c.normalEvent(kvb, "Updating", fmt.Sprintf("Updating keyvaluebucket %q", kvb.Spec.BucketName))
+		if err := c.kvc.Update(c.ctx, kvb); err != nil {
+			if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		res, err := setKeyValueBucketFinalizer(c.ctx, kvb, kif)
+		if err != nil {
+			if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+		kvb = res
+
+		if _, err := setKeyValueBucketSynced(c.ctx, kvb, kif); err != nil {
+			return err
+		}
+		c.normalEvent(kvb, "Updated", fmt.Sprintf("Updated keyvaluebucket %q", kvb.Spec.BucketName))
+		return nil
+	case createOK:
+		c.normalEvent(kvb, "Creating", fmt.Sprintf("Creating keyvaluebucket %q", kvb.Spec.BucketName))
+		if err := c.kvc.Create(c.ctx, kvb); err != nil {
+			if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		res, err := setKeyValueBucketFinalizer(c.ctx, kvb, kif)
+		if err != nil {
+			if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+		kvb = res
+
+		if _, err := setKeyValueBucketSynced(c.ctx, kvb, kif); err != nil {
+			return err
+		}
+		c.normalEvent(kvb, "Created", fmt.Sprintf("Created keyvaluebucket %q", kvb.Spec.BucketName))
+		return err
+	case deleteOK:
+		c.normalEvent(kvb, "Deleting", fmt.Sprintf("Deleting keyvaluebucket %q", kvb.Spec.BucketName))
+		if err := c.kvc.Delete(c.ctx, kvb.Spec.BucketName); err != nil {
+			if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		if _, err := clearKeyValueBucketFinalizer(c.ctx, kvb, kif); err != nil {
+			if _, serr := setKeyValueBucketErrored(c.ctx, kvb, kif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		return nil
+	}
+
+	// default: Nothing to do.
+	return nil
+}
+
+func setKeyValueBucketErrored(ctx context.Context, k *apis.KeyValueBucket, kif typed.KeyValueBucketInterface, err error) (*apis.KeyValueBucket, error) {
+	if err == nil {
+		return k, nil
+	}
+
+	kc := k.DeepCopy()
+	kc.Status.Conditions = upsertKeyValueBucketCondition(kc.Status.Conditions, apis.KeyValueBucketCondition{
+		Type:               kvBucketReadyCondType,
+		Status:             k8sapi.ConditionFalse,
+		LastTransitionTime: time.Now().UTC().Format(time.RFC3339Nano),
+		Reason:             "Errored",
+		Message:            err.Error(),
+	})
+	kc.Status.Conditions = pruneKeyValueBucketConditions(kc.Status.Conditions)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := kif.UpdateStatus(ctx, kc, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set keyvaluebucket errored status: %w", err)
+	}
+
+	return res, nil
+}
+
+func setKeyValueBucketSynced(ctx context.Context, k *apis.KeyValueBucket, kif typed.KeyValueBucketInterface) (*apis.KeyValueBucket, error) {
+	kc := k.DeepCopy()
+
+	kc.Status.ObservedGeneration = k.Generation
+	kc.Status.Conditions = upsertKeyValueBucketCondition(kc.Status.Conditions, apis.KeyValueBucketCondition{
+		Type:               kvBucketReadyCondType,
+		Status:             k8sapi.ConditionTrue,
+		LastTransitionTime: time.Now().UTC().Format(time.RFC3339Nano),
+		Reason:             "Synced",
+		Message:            "KeyValueBucket is synced with spec",
+	})
+	kc.Status.Conditions = pruneKeyValueBucketConditions(kc.Status.Conditions)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := kif.UpdateStatus(ctx, kc, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set %q keyvaluebucket synced status: %w", k.Spec.BucketName, err)
+	}
+
+	return res, nil
+}
+
+func upsertKeyValueBucketCondition(cs []apis.KeyValueBucketCondition, next apis.KeyValueBucketCondition) []apis.KeyValueBucketCondition {
+	for i := 0; i < len(cs); i++ {
+		if cs[i].Type != next.Type {
+			continue
+		}
+
+		cs[i] = next
+		return cs
+	}
+
+	return append(cs, next)
+}
+
+func pruneKeyValueBucketConditions(cs []apis.KeyValueBucketCondition) []apis.KeyValueBucketCondition {
+	const maxCond = 10
+	if len(cs) < maxCond {
+		return cs
+	}
+
+	cs = cs[len(cs)-maxCond:]
+	return cs
+}
+
+func setKeyValueBucketFinalizer(ctx context.Context, k *apis.KeyValueBucket, kif typed.KeyValueBucketInterface) (*apis.KeyValueBucket, error) {
+	fs := k.GetFinalizers()
+	if hasFinalizerKey(fs, kvBucketFinalizerKey) {
+		return k, nil
+	}
+	fs = append(fs, kvBucketFinalizerKey)
+	k.SetFinalizers(fs)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := kif.Update(ctx, k, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set %q keyvaluebucket finalizers: %w", k.GetName(), err)
+	}
+
+	return res, nil
+}
+
+func clearKeyValueBucketFinalizer(ctx context.Context, k *apis.KeyValueBucket, kif typed.KeyValueBucketInterface) (*apis.KeyValueBucket, error) {
+	if k.GetDeletionTimestamp() == nil {
+		// Already deleted.
+		return k, nil
+	}
+
+	fs := k.GetFinalizers()
+	if !hasFinalizerKey(fs, kvBucketFinalizerKey) {
+		return k, nil
+	}
+	var filtered []string
+	for _, f := range fs {
+		if f == kvBucketFinalizerKey {
+			continue
+		}
+		filtered = append(filtered, f)
+	}
+	k.SetFinalizers(filtered)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := kif.Update(ctx, k, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear %q keyvaluebucket finalizers: %w", k.GetName(), err)
+	}
+
+	return res, nil
+}
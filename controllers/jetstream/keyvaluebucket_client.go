@@ -0,0 +1,158 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+)
+
+// keyValueBucketConn adapts the nats.go KeyValue APIs to the
+// UseConn/Exists/Create/Update/Delete shape the reconcile loop expects,
+// mirroring streamConn for the Stream CRD. Unlike the old per-reconcile
+// Connect/Close pair, the *nats.Conn comes from the shared natsConnPool and
+// outlives any single reconcile.
+type keyValueBucketConn struct {
+	nc *nats.Conn
+	js nats.JetStreamContext
+}
+
+// UseConn points the wrapper at a pooled connection, fetching a fresh
+// JetStreamContext only when the underlying *nats.Conn changed.
+func (k *keyValueBucketConn) UseConn(nc *nats.Conn) error {
+	if k.nc == nc && k.js != nil {
+		return nil
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to get jetstream context: %w", err)
+	}
+
+	k.nc, k.js = nc, js
+	return nil
+}
+
+func (k *keyValueBucketConn) Exists(ctx context.Context, bucket string) (bool, error) {
+	_, err := k.js.KeyValue(bucket)
+	if err == nats.ErrBucketNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check keyvaluebucket %q: %w", bucket, err)
+	}
+
+	return true, nil
+}
+
+func (k *keyValueBucketConn) Create(ctx context.Context, kvb *apis.KeyValueBucket) error {
+	if _, err := k.js.CreateKeyValue(keyValueBucketConfig(kvb)); err != nil {
+		return fmt.Errorf("failed to create keyvaluebucket %q: %w", kvb.Spec.BucketName, err)
+	}
+
+	return nil
+}
+
+// Update compares the live bucket's storage and history against the desired
+// spec, refusing if either differs, and otherwise pushes the full desired
+// config with UpdateKeyValue. validateKeyValueBucketUpdate rejects immutable
+// edits only when the CR update event arrives; a later requeue (e.g. on
+// reconnect) can still reach Update with them, hence the check here.
+func (k *keyValueBucketConn) Update(ctx context.Context, kvb *apis.KeyValueBucket) error {
+	kv, err := k.js.KeyValue(kvb.Spec.BucketName)
+	if err != nil {
+		return fmt.Errorf("failed to look up keyvaluebucket %q: %w", kvb.Spec.BucketName, err)
+	}
+
+	status, err := kv.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get keyvaluebucket %q status: %w", kvb.Spec.BucketName, err)
+	}
+
+	want := keyValueBucketConfig(kvb)
+	if have, ok := status.(*nats.KeyValueBucketStatus); ok {
+		if have.StreamInfo().Config.Storage != want.Storage || have.History() != int64(want.History) {
+			return fmt.Errorf("refusing to change immutable keyvaluebucket %q fields, please recreate", kvb.Spec.BucketName)
+		}
+	}
+
+	if _, err := k.js.UpdateKeyValue(want); err != nil {
+		return fmt.Errorf("failed to update keyvaluebucket %q: %w", kvb.Spec.BucketName, err)
+	}
+
+	return nil
+}
+
+func (k *keyValueBucketConn) Delete(ctx context.Context, bucket string) error {
+	if err := k.js.DeleteKeyValue(bucket); err != nil && err != nats.ErrBucketNotFound {
+		return fmt.Errorf("failed to delete keyvaluebucket %q: %w", bucket, err)
+	}
+
+	return nil
+}
+
+func keyValueBucketConfig(kvb *apis.KeyValueBucket) *nats.KeyValueConfig {
+	spec := kvb.Spec
+
+	cfg := &nats.KeyValueConfig{
+		Bucket:       spec.BucketName,
+		Description:  spec.Description,
+		History:      uint8(spec.History),
+		TTL:          spec.TTL,
+		MaxValueSize: spec.MaxValueSize,
+		MaxBytes:     spec.MaxBytes,
+		Storage:      nats.StorageType(spec.Storage),
+		Replicas:     spec.Replicas,
+	}
+
+	if spec.Placement != nil {
+		cfg.Placement = &nats.Placement{
+			Cluster: spec.Placement.Cluster,
+			Tags:    spec.Placement.Tags,
+		}
+	}
+
+	if spec.RePublish != nil {
+		cfg.RePublish = &nats.RePublish{
+			Source:      spec.RePublish.Source,
+			Destination: spec.RePublish.Destination,
+			HeadersOnly: spec.RePublish.HeadersOnly,
+		}
+	}
+
+	if spec.Mirror != nil {
+		cfg.Mirror = streamSourceFromSpec(spec.Mirror)
+	}
+
+	for _, src := range spec.Sources {
+		cfg.Sources = append(cfg.Sources, streamSourceFromSpec(src))
+	}
+
+	return cfg
+}
+
+func streamSourceFromSpec(s *apis.StreamSource) *nats.StreamSource {
+	if s == nil {
+		return nil
+	}
+
+	return &nats.StreamSource{
+		Name:          s.Name,
+		OptStartSeq:   s.OptStartSeq,
+		FilterSubject: s.FilterSubject,
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"errors"
+	"testing"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateKeyValueBucketUpdate(t *testing.T) {
+	base := apis.KeyValueBucketSpec{
+		BucketName:   "orders",
+		Description:  "order events",
+		History:      5,
+		TTL:          "1h",
+		MaxValueSize: 1024,
+		MaxBytes:     1 << 20,
+		Replicas:     3,
+		Storage:      "file",
+	}
+
+	cases := []struct {
+		name     string
+		prev     *apis.KeyValueBucket
+		next     *apis.KeyValueBucket
+		wantErr  bool
+		wantNoop bool
+	}{
+		{
+			name:     "no change: nothing to update",
+			prev:     &apis.KeyValueBucket{Spec: base},
+			next:     &apis.KeyValueBucket{Spec: base},
+			wantNoop: true,
+		},
+		{
+			name: "bucket name changed: rejected",
+			prev: &apis.KeyValueBucket{Spec: base},
+			next: &apis.KeyValueBucket{Spec: func() apis.KeyValueBucketSpec {
+				s := base
+				s.BucketName = "orders-v2"
+				return s
+			}()},
+			wantErr: true,
+		},
+		{
+			name: "history changed: rejected",
+			prev: &apis.KeyValueBucket{Spec: base},
+			next: &apis.KeyValueBucket{Spec: func() apis.KeyValueBucketSpec {
+				s := base
+				s.History = 10
+				return s
+			}()},
+			wantErr: true,
+		},
+		{
+			name: "storage changed: rejected",
+			prev: &apis.KeyValueBucket{Spec: base},
+			next: &apis.KeyValueBucket{Spec: func() apis.KeyValueBucketSpec {
+				s := base
+				s.Storage = "memory"
+				return s
+			}()},
+			wantErr: true,
+		},
+		{
+			name: "description changed: allowed",
+			prev: &apis.KeyValueBucket{Spec: base},
+			next: &apis.KeyValueBucket{Spec: func() apis.KeyValueBucketSpec {
+				s := base
+				s.Description = "renamed"
+				return s
+			}()},
+		},
+		{
+			name: "deletion timestamp set: allowed through regardless of other fields",
+			prev: &apis.KeyValueBucket{Spec: base},
+			next: &apis.KeyValueBucket{
+				ObjectMeta: k8smeta.ObjectMeta{DeletionTimestamp: &k8smeta.Time{}},
+				Spec: func() apis.KeyValueBucketSpec {
+					s := base
+					s.BucketName = "orders-v2"
+					return s
+				}(),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateKeyValueBucketUpdate(tc.prev, tc.next)
+			switch {
+			case tc.wantNoop:
+				if !errors.Is(err, errNothingToUpdate) {
+					t.Errorf("validateKeyValueBucketUpdate() = %v, want errNothingToUpdate", err)
+				}
+			case tc.wantErr:
+				if err == nil || errors.Is(err, errNothingToUpdate) {
+					t.Errorf("validateKeyValueBucketUpdate() = %v, want a rejection error", err)
+				}
+			default:
+				if err != nil {
+					t.Errorf("validateKeyValueBucketUpdate() = %v, want nil", err)
+				}
+			}
+		})
+	}
+}
+
+func TestEqualKeyValueBucketSpec(t *testing.T) {
+	a := apis.KeyValueBucketSpec{BucketName: "orders", History: 5, TTL: "1h", MaxValueSize: 1024, MaxBytes: 1 << 20, Replicas: 3, Storage: "file"}
+
+	if !equalKeyValueBucketSpec(a, a) {
+		t.Error("equalKeyValueBucketSpec() = false for identical specs, want true")
+	}
+
+	b := a
+	b.MaxBytes = a.MaxBytes + 1
+	if equalKeyValueBucketSpec(a, b) {
+		t.Error("equalKeyValueBucketSpec() = true for specs differing in MaxBytes, want false")
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+
+	shardRefreshInterval = 30 * time.Second
+)
+
+// haConfig configures active/standby leader election and, optionally,
+// active/active sharding across a fleet of controller replicas.
+type haConfig struct {
+	Namespace string
+	LeaseName string
+	Identity  string
+
+	// Sharded turns on active/active mode: every replica runs, but each
+	// only reconciles the CRs consistent-hashing assigns it.
+	Sharded bool
+	// ReplicaService is the headless Service whose endpoint hostnames are
+	// used as the set of sharding identities.
+	ReplicaService string
+}
+
+// runHA wraps start with leader election (active/standby) unless sharding is
+// enabled, in which case start just runs directly on every replica and
+// per-item sharding happens in ownsShard.
+func (c *Controller) runHA(ctx context.Context, ki kubernetes.Interface, cfg haConfig, start func(context.Context)) error {
+	if cfg.Sharded {
+		start(ctx)
+		return nil
+	}
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		cfg.Namespace,
+		cfg.LeaseName,
+		ki.CoreV1(),
+		ki.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to build leader election lock: %w", err)
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				c.leading.Store(true)
+				start(ctx)
+			},
+			OnStoppedLeading: func() {
+				c.leading.Store(false)
+			},
+		},
+	})
+
+	return nil
+}
+
+// shardReplicas returns the current fleet of sharded replica identities,
+// refreshing the cache every shardRefreshInterval so ownsShard tracks
+// scale-up/down without hitting the API server on every queue item.
+func (c *Controller) shardReplicas() []string {
+	c.shardCacheMu.Lock()
+	defer c.shardCacheMu.Unlock()
+
+	if time.Since(c.shardCacheAt) < shardRefreshInterval && c.shardCache != nil {
+		return c.shardCache
+	}
+
+	identities, err := listReplicaIdentities(c.ctx, c.ki, c.ha)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to refresh shard replicas: %w", err))
+		return c.shardCache
+	}
+
+	c.shardCache, c.shardCacheAt = identities, time.Now()
+	return c.shardCache
+}
+
+// ownsShard reports whether this replica is responsible for itemKey under
+// active/active sharding, using rendezvous (highest random weight) hashing
+// so the assignment stays stable as replicas join or leave.
+func ownsShard(identity string, replicas []string, itemKey string) bool {
+	if len(replicas) == 0 {
+		return true
+	}
+
+	var best string
+	var bestWeight uint32
+	for _, r := range replicas {
+		w := rendezvousWeight(r, itemKey)
+		if best == "" || w > bestWeight {
+			best, bestWeight = r, w
+		}
+	}
+
+	return best == identity
+}
+
+func rendezvousWeight(replica, itemKey string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(replica))
+	h.Write([]byte{'|'})
+	h.Write([]byte(itemKey))
+	return h.Sum32()
+}
+
+// listReplicaIdentities resolves the ready endpoint hostnames behind
+// cfg.ReplicaService, i.e. the current fleet of sharded replicas.
+func listReplicaIdentities(ctx context.Context, ki kubernetes.Interface, cfg haConfig) ([]string, error) {
+	eps, err := ki.CoreV1().Endpoints(cfg.Namespace).Get(ctx, cfg.ReplicaService, k8smeta.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replicas behind %q: %w", cfg.ReplicaService, err)
+	}
+
+	var identities []string
+	for _, subset := range eps.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.Hostname != "" {
+				identities = append(identities, addr.Hostname)
+			} else {
+				identities = append(identities, addr.IP)
+			}
+		}
+	}
+
+	return identities, nil
+}
+
+// healthHandler serves /healthz (liveness: OK once the NATS connection pool
+// exists; the state of the pooled connections is not checked) and /readyz
+// (readiness: requires leadership when running active/standby).
+func (c *Controller) healthHandler(requireLeader bool) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if c.connPool == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if requireLeader && !c.leading.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "not leader")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return mux
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import "testing"
+
+func TestOwnsShardNoReplicas(t *testing.T) {
+	if !ownsShard("replica-a", nil, "default/orders") {
+		t.Error("ownsShard() = false with no known replicas, want true so items aren't dropped before the fleet is discovered")
+	}
+}
+
+func TestOwnsShardExactlyOneOwner(t *testing.T) {
+	replicas := []string{"replica-a", "replica-b", "replica-c"}
+	itemKey := "default/orders"
+
+	owners := 0
+	for _, r := range replicas {
+		if ownsShard(r, replicas, itemKey) {
+			owners++
+		}
+	}
+
+	if owners != 1 {
+		t.Errorf("got %d replicas claiming ownership of %q, want exactly 1", owners, itemKey)
+	}
+}
+
+func TestOwnsShardStableUnderReplicaChurn(t *testing.T) {
+	itemKey := "default/orders"
+	before := []string{"replica-a", "replica-b", "replica-c"}
+
+	var owner string
+	for _, r := range before {
+		if ownsShard(r, before, itemKey) {
+			owner = r
+		}
+	}
+
+	if owner == "" {
+		t.Fatal("no owner found among original replicas")
+	}
+
+	// Adding a replica should only move ownership for items that rendezvous
+	// to the new replica, never silently drop the item's sole owner.
+	after := append(append([]string(nil), before...), "replica-d")
+	owners := 0
+	var newOwner string
+	for _, r := range after {
+		if ownsShard(r, after, itemKey) {
+			owners++
+			newOwner = r
+		}
+	}
+	if owners != 1 {
+		t.Errorf("got %d owners after scale-up, want exactly 1", owners)
+	}
+	if newOwner != owner && newOwner != "replica-d" {
+		t.Errorf("owner moved from %q to %q after scale-up, want it unchanged or moved to the new replica", owner, newOwner)
+	}
+}
+
+func TestRendezvousWeightDeterministic(t *testing.T) {
+	a := rendezvousWeight("replica-a", "default/orders")
+	b := rendezvousWeight("replica-a", "default/orders")
+	if a != b {
+		t.Errorf("rendezvousWeight() is not deterministic: %d != %d", a, b)
+	}
+}
+
+func TestRendezvousWeightVariesByReplica(t *testing.T) {
+	a := rendezvousWeight("replica-a", "default/orders")
+	b := rendezvousWeight("replica-b", "default/orders")
+	if a == b {
+		t.Error("rendezvousWeight() gave the same weight for two different replicas; hashing isn't distinguishing them")
+	}
+}
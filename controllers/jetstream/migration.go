@@ -0,0 +1,287 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+	typed "github.com/nats-io/nack/pkg/jetstream/generated/clientset/versioned/typed/jetstream/v1"
+
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Migration phases for apis.MigrationStatus.Phase, driving
+// reconcileStreamMigration one requeue at a time.
+const (
+	migrationPhaseCreatingMirror = "CreatingMirror"
+	migrationPhaseAwaitingLag    = "AwaitingLag"
+	migrationPhaseCutover        = "Cutover"
+	migrationPhaseCleanup        = "Cleanup"
+	migrationPhaseDone           = "Done"
+)
+
+func migrateStreamName(name string) string {
+	return name + "-migrate"
+}
+
+// streamNeedsMigration reports whether stream is mid-migration, or has just
+// had an immutable field edited under spec.migrationStrategy: Mirror and
+// needs one kicked off.
+func streamNeedsMigration(stream *apis.Stream) bool {
+	if stream.Spec.MigrationStrategy != apis.MigrationStrategyMirror {
+		return false
+	}
+
+	if stream.Status.MigrationStatus != nil && stream.Status.MigrationStatus.Phase != migrationPhaseDone {
+		return true
+	}
+
+	return stream.Status.LastAppliedName != "" &&
+		(stream.Status.LastAppliedName != stream.Spec.Name || stream.Status.LastAppliedStorage != stream.Spec.Storage)
+}
+
+// reconcileStreamMigration drives one step of the mirror-and-cutover
+// migration per call, relying on the normal requeue-with-backoff path to
+// come back for the next step rather than blocking the worker on it.
+func (c *Controller) reconcileStreamMigration(stream *apis.Stream, sif typed.StreamInterface) error {
+	ms := stream.Status.MigrationStatus
+	if ms == nil {
+		ms = &apis.MigrationStatus{Phase: migrationPhaseCreatingMirror, FromName: stream.Status.LastAppliedName}
+	}
+
+	var err error
+	switch ms.Phase {
+	case migrationPhaseCreatingMirror:
+		err = c.migrationCreateMirror(stream, ms)
+	case migrationPhaseAwaitingLag:
+		err = c.migrationAwaitLag(ms)
+	case migrationPhaseCutover:
+		err = c.migrationCutover(stream, ms)
+	case migrationPhaseCleanup:
+		err = c.migrationCleanup(ms)
+	default:
+		ms.Phase = migrationPhaseDone
+	}
+
+	if err != nil {
+		ms.LastError = err.Error()
+		if _, serr := c.setStreamMigrationStatus(stream, sif, ms); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		// Let the queue's rate limiter back off instead of erroring the CR.
+		return err
+	}
+
+	ms.LastError = ""
+	res, err := c.setStreamMigrationStatus(stream, sif, ms)
+	if err != nil {
+		return err
+	}
+	stream = res
+
+	if ms.Phase == migrationPhaseDone {
+		c.normalEvent(stream, "Migrated", fmt.Sprintf("Finished migrating stream %q", stream.Spec.Name))
+		return nil
+	}
+
+	// Still in progress: requeue for the next phase through the queue's rate
+	// limiter. processNextQueueItem calls Forget once this returns nil, which
+	// resets the item's backoff, so every step waits the limiter's first-step
+	// delay and AwaitingLag's polling of Info() does not slow down over time.
+	key, err := enqueueStreamWorkKey(stream)
+	if err != nil {
+		return err
+	}
+	c.streamQueue.AddRateLimited(key)
+	return nil
+}
+
+func (c *Controller) migrationCreateMirror(stream *apis.Stream, ms *apis.MigrationStatus) error {
+	mirror := stream.DeepCopy()
+	mirror.Spec.Name = migrateStreamName(ms.FromName)
+	mirror.Spec.Mirror = &apis.StreamSource{Name: ms.FromName}
+
+	exists, err := c.sc.Exists(c.ctx, mirror.Spec.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check migration mirror %q: %w", mirror.Spec.Name, err)
+	}
+	if !exists {
+		if err := c.sc.Create(c.ctx, mirror); err != nil {
+			return fmt.Errorf("failed to create migration mirror %q: %w", mirror.Spec.Name, err)
+		}
+	}
+
+	ms.Phase = migrationPhaseAwaitingLag
+	return nil
+}
+
+// migrationMirrorConverged checks whether the migration mirror has caught up
+// to its source (zero lag, matching message counts), updating ms.Lag either
+// way. It's shared by migrationAwaitLag and migrationCutover, the latter
+// re-checking immediately before the destructive part of cutover so a mirror
+// that fell behind again between requeues doesn't get cut over anyway.
+func (c *Controller) migrationMirrorConverged(mirrorName string, ms *apis.MigrationStatus) (bool, error) {
+	mirrorInfo, err := c.sc.Info(c.ctx, mirrorName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get migration mirror %q status: %w", mirrorName, err)
+	}
+
+	sourceInfo, err := c.sc.Info(c.ctx, ms.FromName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get pre-migration stream %q status: %w", ms.FromName, err)
+	}
+
+	ms.Lag = mirrorInfo.Mirror.Lag
+	return mirrorInfo.Mirror.Lag == 0 && mirrorInfo.State.Msgs == sourceInfo.State.Msgs, nil
+}
+
+func (c *Controller) migrationAwaitLag(ms *apis.MigrationStatus) error {
+	converged, err := c.migrationMirrorConverged(migrateStreamName(ms.FromName), ms)
+	if err != nil {
+		return err
+	}
+	if !converged {
+		// Still catching up; come back on the next requeue.
+		return nil
+	}
+
+	ms.Phase = migrationPhaseCutover
+	return nil
+}
+
+// migrationCutover creates the final stream sourcing from the migration
+// mirror before deleting the original, so a failed or interrupted Create
+// leaves the source intact and resumable instead of losing data between the
+// two calls. For a rename (stream.Spec.Name != ms.FromName) the final name is
+// already free, so it's created first. For a storage-only migration the
+// final name IS ms.FromName, so it can't be created until the original is
+// deleted first — that's still safe because convergence was just confirmed,
+// so the mirror alone already holds a complete copy under the new config.
+// Every step is Exists-guarded, so resuming after a crash at any point just
+// picks up at whichever step hasn't happened yet.
+func (c *Controller) migrationCutover(stream *apis.Stream, ms *apis.MigrationStatus) error {
+	mirrorName := migrateStreamName(ms.FromName)
+	renamed := stream.Spec.Name != ms.FromName
+
+	sourceExists, err := c.sc.Exists(c.ctx, ms.FromName)
+	if err != nil {
+		return fmt.Errorf("failed to check pre-migration stream %q: %w", ms.FromName, err)
+	}
+
+	if sourceExists {
+		converged, err := c.migrationMirrorConverged(mirrorName, ms)
+		if err != nil {
+			return err
+		}
+		if !converged {
+			// Fell behind again since AwaitingLag last checked; wait some
+			// more rather than cutting over onto a stale mirror.
+			ms.Phase = migrationPhaseAwaitingLag
+			return nil
+		}
+
+		if renamed {
+			// The final name is free; create it before touching the
+			// source, so a failed Create leaves the source fully intact.
+			if err := c.createMigratedStream(stream, mirrorName); err != nil {
+				return err
+			}
+		}
+
+		if err := c.sc.Delete(c.ctx, ms.FromName); err != nil {
+			return fmt.Errorf("failed to delete pre-migration stream %q: %w", ms.FromName, err)
+		}
+	}
+
+	if !renamed {
+		// stream.Spec.Name == ms.FromName, so this could only be created
+		// once the delete above (here, or on a prior attempt at this
+		// phase) freed the name.
+		if err := c.createMigratedStream(stream, mirrorName); err != nil {
+			return err
+		}
+	}
+
+	ms.Phase = migrationPhaseCleanup
+	return nil
+}
+
+func (c *Controller) createMigratedStream(stream *apis.Stream, mirrorName string) error {
+	exists, err := c.sc.Exists(c.ctx, stream.Spec.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check post-migration stream %q: %w", stream.Spec.Name, err)
+	}
+	if exists {
+		return nil
+	}
+
+	final := stream.DeepCopy()
+	final.Spec.Sources = append(final.Spec.Sources, &apis.StreamSource{Name: mirrorName})
+	if err := c.sc.Create(c.ctx, final); err != nil {
+		return fmt.Errorf("failed to create post-migration stream %q: %w", final.Spec.Name, err)
+	}
+
+	return nil
+}
+
+// migrationCleanup removes the now-unneeded source stream and mirror,
+// guarding each with an Exists check so a resumed/retried cleanup doesn't
+// error on an already-deleted stream.
+func (c *Controller) migrationCleanup(ms *apis.MigrationStatus) error {
+	sourceExists, err := c.sc.Exists(c.ctx, ms.FromName)
+	if err != nil {
+		return fmt.Errorf("failed to check pre-migration stream %q: %w", ms.FromName, err)
+	}
+	if sourceExists {
+		if err := c.sc.Delete(c.ctx, ms.FromName); err != nil {
+			return fmt.Errorf("failed to delete pre-migration stream %q: %w", ms.FromName, err)
+		}
+	}
+
+	mirrorName := migrateStreamName(ms.FromName)
+	mirrorExists, err := c.sc.Exists(c.ctx, mirrorName)
+	if err != nil {
+		return fmt.Errorf("failed to check migration mirror %q: %w", mirrorName, err)
+	}
+	if mirrorExists {
+		if err := c.sc.Delete(c.ctx, mirrorName); err != nil {
+			return fmt.Errorf("failed to delete migration mirror %q: %w", mirrorName, err)
+		}
+	}
+
+	ms.Phase = migrationPhaseDone
+	return nil
+}
+
+func (c *Controller) setStreamMigrationStatus(s *apis.Stream, sif typed.StreamInterface, ms *apis.MigrationStatus) (*apis.Stream, error) {
+	sc := s.DeepCopy()
+	sc.Status.MigrationStatus = ms
+	if ms.Phase == migrationPhaseDone {
+		sc.Status.LastAppliedName = s.Spec.Name
+		sc.Status.LastAppliedStorage = s.Spec.Storage
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := sif.UpdateStatus(ctx, sc, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set %q stream migration status: %w", s.Spec.Name, err)
+	}
+
+	return res, nil
+}
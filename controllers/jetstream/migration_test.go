@@ -0,0 +1,110 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"testing"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+)
+
+func TestStreamNeedsMigration(t *testing.T) {
+	cases := []struct {
+		name   string
+		stream *apis.Stream
+		want   bool
+	}{
+		{
+			name:   "no migration strategy: never needs migration",
+			stream: &apis.Stream{Spec: apis.StreamSpec{Name: "orders"}},
+			want:   false,
+		},
+		{
+			name: "mirror strategy, unchanged name/storage: no migration needed",
+			stream: &apis.Stream{
+				Spec: apis.StreamSpec{
+					Name:              "orders",
+					Storage:           "file",
+					MigrationStrategy: apis.MigrationStrategyMirror,
+				},
+				Status: apis.StreamStatus{LastAppliedName: "orders", LastAppliedStorage: "file"},
+			},
+			want: false,
+		},
+		{
+			name: "mirror strategy, renamed: migration needed",
+			stream: &apis.Stream{
+				Spec: apis.StreamSpec{
+					Name:              "orders-v2",
+					Storage:           "file",
+					MigrationStrategy: apis.MigrationStrategyMirror,
+				},
+				Status: apis.StreamStatus{LastAppliedName: "orders", LastAppliedStorage: "file"},
+			},
+			want: true,
+		},
+		{
+			name: "mirror strategy, storage changed: migration needed",
+			stream: &apis.Stream{
+				Spec: apis.StreamSpec{
+					Name:              "orders",
+					Storage:           "memory",
+					MigrationStrategy: apis.MigrationStrategyMirror,
+				},
+				Status: apis.StreamStatus{LastAppliedName: "orders", LastAppliedStorage: "file"},
+			},
+			want: true,
+		},
+		{
+			name: "mirror strategy, migration already in progress: still needed",
+			stream: &apis.Stream{
+				Spec: apis.StreamSpec{
+					Name:              "orders",
+					Storage:           "file",
+					MigrationStrategy: apis.MigrationStrategyMirror,
+				},
+				Status: apis.StreamStatus{
+					LastAppliedName:    "orders",
+					LastAppliedStorage: "file",
+					MigrationStatus:    &apis.MigrationStatus{Phase: migrationPhaseAwaitingLag},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "mirror strategy, migration finished: no longer needed",
+			stream: &apis.Stream{
+				Spec: apis.StreamSpec{
+					Name:              "orders",
+					Storage:           "file",
+					MigrationStrategy: apis.MigrationStrategyMirror,
+				},
+				Status: apis.StreamStatus{
+					LastAppliedName:    "orders",
+					LastAppliedStorage: "file",
+					MigrationStatus:    &apis.MigrationStatus{Phase: migrationPhaseDone},
+				},
+			},
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := streamNeedsMigration(tc.stream); got != tc.want {
+				t.Errorf("streamNeedsMigration() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
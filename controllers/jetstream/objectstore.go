@@ -0,0 +1,468 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+	typed "github.com/nats-io/nack/pkg/jetstream/generated/clientset/versioned/typed/jetstream/v1"
+
+	k8sapi "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	objectStoreFinalizerKey  = "objectstorefinalizer.jetstream.nats.io"
+	objectStoreReadyCondType = "Ready"
+)
+
+func objectStoreEventHandlers(ctx context.Context, q workqueue.RateLimitingInterface, jif typed.JetstreamV1Interface) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			os, ok := obj.(*apis.ObjectStore)
+			if !ok {
+				return
+			}
+
+			if err := enqueueObjectStoreWork(q, os); err != nil {
+				utilruntime.HandleError(err)
+			}
+		},
+		UpdateFunc: func(prevObj, nextObj interface{}) {
+			prev, ok := prevObj.(*apis.ObjectStore)
+			if !ok {
+				return
+			}
+
+			next, ok := nextObj.(*apis.ObjectStore)
+			if !ok {
+				return
+			}
+
+			if err := validateObjectStoreUpdate(prev, next); errors.Is(err, errNothingToUpdate) {
+				return
+			} else if err != nil {
+				oif := jif.ObjectStores(next.Namespace)
+				if _, serr := setObjectStoreErrored(ctx, next, oif, err); serr != nil {
+					err = fmt.Errorf("%s: %w", err, serr)
+				}
+
+				utilruntime.HandleError(err)
+				return
+			}
+
+			if err := enqueueObjectStoreWork(q, next); err != nil {
+				utilruntime.HandleError(err)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			os, ok := obj.(*apis.ObjectStore)
+			if !ok {
+				return
+			}
+
+			if err := enqueueObjectStoreWork(q, os); err != nil {
+				utilruntime.HandleError(err)
+			}
+		},
+	}
+}
+
+func enqueueObjectStoreWork(q workqueue.RateLimitingInterface, os *apis.ObjectStore) (err error) {
+	key, err := cache.MetaNamespaceKeyFunc(os)
+	if err != nil {
+		return fmt.Errorf("failed to queue objectstore work: %w", err)
+	}
+
+	q.Add(key)
+	return nil
+}
+
+func validateObjectStoreUpdate(prev, next *apis.ObjectStore) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("failed to validate update: %w", err)
+		}
+	}()
+
+	if prev.DeletionTimestamp != next.DeletionTimestamp {
+		return nil
+	}
+
+	if prev.Spec.BucketName != next.Spec.BucketName {
+		return fmt.Errorf("updating bucket name is not allowed, please recreate")
+	}
+	if prev.Spec.Storage != next.Spec.Storage {
+		return fmt.Errorf("updating bucket storage is not allowed, please recreate")
+	}
+
+	if equalObjectStoreSpec(prev.Spec, next.Spec) {
+		return errNothingToUpdate
+	}
+
+	return nil
+}
+
+func equalObjectStoreSpec(a, b apis.ObjectStoreSpec) bool {
+	return a.BucketName == b.BucketName &&
+		a.Description == b.Description &&
+		a.TTL == b.TTL &&
+		a.MaxBytes == b.MaxBytes &&
+		a.Replicas == b.Replicas &&
+		a.Storage == b.Storage
+}
+
+func (c *Controller) runObjectStoreQueue() {
+	for {
+		c.processNextObjectStoreQueueItem()
+	}
+}
+
+func (c *Controller) processNextObjectStoreQueueItem() {
+	item, shutdown := c.objectStoreQueue.Get()
+	if shutdown {
+		return
+	}
+	defer c.objectStoreQueue.Done(item)
+
+	ns, name, err := splitNamespaceName(item)
+	if err != nil {
+		// Probably junk, clean it up.
+		utilruntime.HandleError(err)
+		c.objectStoreQueue.Forget(item)
+		return
+	}
+
+	if c.ha.Sharded && !ownsShard(c.ha.Identity, c.shardReplicas(), ns+"/"+name) {
+		// A different replica owns this CR; drop it without requeueing.
+		c.objectStoreQueue.Forget(item)
+		return
+	}
+
+	err = c.processObjectStore(ns, name)
+	if err == nil {
+		// Item processed successfully, don't requeue.
+		c.objectStoreQueue.Forget(item)
+		return
+	}
+
+	utilruntime.HandleError(err)
+
+	if c.objectStoreQueue.NumRequeues(item) < maxQueueRetries {
+		// Failed to process item, try again.
+		c.objectStoreQueue.AddRateLimited(item)
+		return
+	}
+
+	// If we haven't been able to recover by this point, then just stop.
+	// The user should have enough info in kubectl describe to debug.
+	c.objectStoreQueue.Forget(item)
+}
+
+func (c *Controller) processObjectStore(ns, name string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("failed to process objectstore: %w", err)
+		}
+	}()
+
+	os, err := c.objectStoreLister.ObjectStores(ns).Get(name)
+	if err != nil && k8serrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	oif := c.ji.ObjectStores(os.Namespace)
+
+	key, err := cache.MetaNamespaceKeyFunc(os)
+	if err != nil {
+		return fmt.Errorf("failed to queue objectstore work: %w", err)
+	}
+
+	connKey := connKey(os.Spec.Servers, "")
+	nc, err := c.connPool.Get(connKey, strings.Join(os.Spec.Servers, ","), getNATSOptions(c.natsName)...)
+	if err != nil {
+		if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+	c.connPool.Register(connKey, c.objectStoreQueue, key)
+
+	if err := c.osc.UseConn(nc); err != nil {
+		if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+
+	deleteOK := os.GetDeletionTimestamp() != nil
+	newGeneration := os.Generation != os.Status.ObservedGeneration
+	storeExists, err := c.osc.Exists(c.ctx, os.Spec.BucketName)
+	if err != nil {
+		if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+	updateOK := (storeExists && !deleteOK && newGeneration)
+	createOK := (!storeExists && !deleteOK && newGeneration)
+
+	switch {
+	case updateOK:
+		c.normalEvent(os, "Updating", fmt.Sprintf("Updating objectstore %q", os.Spec.BucketName))
+		if err := c.osc.Update(c.ctx, os); err != nil {
+			if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		res, err := setObjectStoreFinalizer(c.ctx, os, oif)
+		if err != nil {
+			if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+		os = res
+
+		if err := c.seedObjectStore(os); err != nil {
+			if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		if _, err := c.setObjectStoreSynced(os, oif); err != nil {
+			return err
+		}
+		c.normalEvent(os, "Updated", fmt.Sprintf("Updated objectstore %q", os.Spec.BucketName))
+		return nil
+	case createOK:
+		c.normalEvent(os, "Creating", fmt.Sprintf("Creating objectstore %q", os.Spec.BucketName))
+		if err := c.osc.Create(c.ctx, os); err != nil {
+			if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		res, err := setObjectStoreFinalizer(c.ctx, os, oif)
+		if err != nil {
+			if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+		os = res
+
+		if err := c.seedObjectStore(os); err != nil {
+			if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		if _, err := c.setObjectStoreSynced(os, oif); err != nil {
+			return err
+		}
+		c.normalEvent(os, "Created", fmt.Sprintf("Created objectstore %q", os.Spec.BucketName))
+		return err
+	case deleteOK:
+		c.normalEvent(os, "Deleting", fmt.Sprintf("Deleting objectstore %q", os.Spec.BucketName))
+		if err := c.osc.Delete(c.ctx, os.Spec.BucketName); err != nil {
+			if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		if _, err := clearObjectStoreFinalizer(c.ctx, os, oif); err != nil {
+			if _, serr := setObjectStoreErrored(c.ctx, os, oif, err); serr != nil {
+				return fmt.Errorf("%s: %w", err, serr)
+			}
+			return err
+		}
+
+		return nil
+	}
+
+	// default: Nothing to do.
+	return nil
+}
+
+// seedObjectStore uploads the blobs referenced by Spec.Seed into the bucket,
+// skipping any object name that already exists so seeding stays idempotent
+// across reconciles.
+func (c *Controller) seedObjectStore(os *apis.ObjectStore) error {
+	if os.Spec.Seed == nil {
+		return nil
+	}
+
+	blobs, err := c.osc.LoadSeed(c.ctx, os.Namespace, os.Spec.Seed)
+	if err != nil {
+		return fmt.Errorf("failed to load objectstore seed: %w", err)
+	}
+
+	for name, data := range blobs {
+		if err := c.osc.PutIfAbsent(c.ctx, os.Spec.BucketName, name, data); err != nil {
+			return fmt.Errorf("failed to seed object %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setObjectStoreErrored(ctx context.Context, o *apis.ObjectStore, oif typed.ObjectStoreInterface, err error) (*apis.ObjectStore, error) {
+	if err == nil {
+		return o, nil
+	}
+
+	oc := o.DeepCopy()
+	oc.Status.Conditions = upsertObjectStoreCondition(oc.Status.Conditions, apis.ObjectStoreCondition{
+		Type:               objectStoreReadyCondType,
+		Status:             k8sapi.ConditionFalse,
+		LastTransitionTime: time.Now().UTC().Format(time.RFC3339Nano),
+		Reason:             "Errored",
+		Message:            err.Error(),
+	})
+	oc.Status.Conditions = pruneObjectStoreConditions(oc.Status.Conditions)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := oif.UpdateStatus(ctx, oc, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set objectstore errored status: %w", err)
+	}
+
+	return res, nil
+}
+
+// setObjectStoreSynced marks the bucket Ready and refreshes the observed
+// size/object/chunk counts from the live bucket status.
+func (c *Controller) setObjectStoreSynced(o *apis.ObjectStore, oif typed.ObjectStoreInterface) (*apis.ObjectStore, error) {
+	oc := o.DeepCopy()
+
+	info, err := c.osc.Status(c.ctx, o.Spec.BucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %q objectstore status: %w", o.Spec.BucketName, err)
+	}
+	oc.Status.Size = info.Size
+	oc.Status.Objects = info.Objects
+	oc.Status.Chunks = info.Chunks
+
+	oc.Status.ObservedGeneration = o.Generation
+	oc.Status.Conditions = upsertObjectStoreCondition(oc.Status.Conditions, apis.ObjectStoreCondition{
+		Type:               objectStoreReadyCondType,
+		Status:             k8sapi.ConditionTrue,
+		LastTransitionTime: time.Now().UTC().Format(time.RFC3339Nano),
+		Reason:             "Synced",
+		Message:            "ObjectStore is synced with spec",
+	})
+	oc.Status.Conditions = pruneObjectStoreConditions(oc.Status.Conditions)
+
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := oif.UpdateStatus(ctx, oc, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set %q objectstore synced status: %w", o.Spec.BucketName, err)
+	}
+
+	return res, nil
+}
+
+func upsertObjectStoreCondition(cs []apis.ObjectStoreCondition, next apis.ObjectStoreCondition) []apis.ObjectStoreCondition {
+	for i := 0; i < len(cs); i++ {
+		if cs[i].Type != next.Type {
+			continue
+		}
+
+		cs[i] = next
+		return cs
+	}
+
+	return append(cs, next)
+}
+
+func pruneObjectStoreConditions(cs []apis.ObjectStoreCondition) []apis.ObjectStoreCondition {
+	const maxCond = 10
+	if len(cs) < maxCond {
+		return cs
+	}
+
+	cs = cs[len(cs)-maxCond:]
+	return cs
+}
+
+func setObjectStoreFinalizer(ctx context.Context, o *apis.ObjectStore, oif typed.ObjectStoreInterface) (*apis.ObjectStore, error) {
+	fs := o.GetFinalizers()
+	if hasFinalizerKey(fs, objectStoreFinalizerKey) {
+		return o, nil
+	}
+	fs = append(fs, objectStoreFinalizerKey)
+	o.SetFinalizers(fs)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := oif.Update(ctx, o, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set %q objectstore finalizers: %w", o.GetName(), err)
+	}
+
+	return res, nil
+}
+
+func clearObjectStoreFinalizer(ctx context.Context, o *apis.ObjectStore, oif typed.ObjectStoreInterface) (*apis.ObjectStore, error) {
+	if o.GetDeletionTimestamp() == nil {
+		// Already deleted.
+		return o, nil
+	}
+
+	fs := o.GetFinalizers()
+	if !hasFinalizerKey(fs, objectStoreFinalizerKey) {
+		return o, nil
+	}
+	var filtered []string
+	for _, f := range fs {
+		if f == objectStoreFinalizerKey {
+			continue
+		}
+		filtered = append(filtered, f)
+	}
+	o.SetFinalizers(filtered)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := oif.Update(ctx, o, k8smeta.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear %q objectstore finalizers: %w", o.GetName(), err)
+	}
+
+	return res, nil
+}
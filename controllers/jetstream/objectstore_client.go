@@ -0,0 +1,200 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// objectStoreConn adapts the nats.go Object Store APIs to the
+// UseConn/Exists/Create/Update/Delete shape the reconcile loop expects,
+// mirroring streamConn for the Stream CRD. The *nats.Conn comes from the
+// shared natsConnPool rather than being dialed per reconcile.
+type objectStoreConn struct {
+	nc *nats.Conn
+	js nats.JetStreamContext
+	ki kubernetes.Interface
+}
+
+// objectStoreInfo is the subset of an Object Store's live status that gets
+// surfaced onto the CR's status sub-resource.
+type objectStoreInfo struct {
+	Size    uint64
+	Objects uint64
+	Chunks  uint64
+}
+
+// UseConn points the wrapper at a pooled connection, fetching a fresh
+// JetStreamContext only when the underlying *nats.Conn changed.
+func (o *objectStoreConn) UseConn(nc *nats.Conn) error {
+	if o.nc == nc && o.js != nil {
+		return nil
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to get jetstream context: %w", err)
+	}
+
+	o.nc, o.js = nc, js
+	return nil
+}
+
+func (o *objectStoreConn) Exists(ctx context.Context, bucket string) (bool, error) {
+	_, err := o.js.ObjectStore(bucket)
+	if err == nats.ErrStreamNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check objectstore %q: %w", bucket, err)
+	}
+
+	return true, nil
+}
+
+func (o *objectStoreConn) Create(ctx context.Context, os *apis.ObjectStore) error {
+	if _, err := o.js.CreateObjectStore(objectStoreConfig(os)); err != nil {
+		return fmt.Errorf("failed to create objectstore %q: %w", os.Spec.BucketName, err)
+	}
+
+	return nil
+}
+
+func (o *objectStoreConn) Update(ctx context.Context, os *apis.ObjectStore) error {
+	if _, err := o.js.UpdateObjectStore(objectStoreConfig(os)); err != nil {
+		return fmt.Errorf("failed to update objectstore %q: %w", os.Spec.BucketName, err)
+	}
+
+	return nil
+}
+
+func (o *objectStoreConn) Delete(ctx context.Context, bucket string) error {
+	if err := o.js.DeleteObjectStore(bucket); err != nil && err != nats.ErrStreamNotFound {
+		return fmt.Errorf("failed to delete objectstore %q: %w", bucket, err)
+	}
+
+	return nil
+}
+
+func (o *objectStoreConn) Status(ctx context.Context, bucket string) (*objectStoreInfo, error) {
+	store, err := o.js.ObjectStore(bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up objectstore %q: %w", bucket, err)
+	}
+
+	status, err := store.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get objectstore %q status: %w", bucket, err)
+	}
+
+	objs, err := store.List()
+	if err != nil && err != nats.ErrNoObjectsFound {
+		return nil, fmt.Errorf("failed to list objectstore %q objects: %w", bucket, err)
+	}
+
+	var chunks uint64
+	for _, obj := range objs {
+		chunks += obj.NumChunks
+	}
+
+	return &objectStoreInfo{
+		Size:    status.Size(),
+		Objects: uint64(len(objs)),
+		Chunks:  chunks,
+	}, nil
+}
+
+// LoadSeed resolves Spec.Seed into a name->contents map, reading blobs from
+// a Secret, a ConfigMap (both Data and BinaryData), or both, in the CR's
+// namespace. Where both hold the same name, the ConfigMap's entry wins.
+func (o *objectStoreConn) LoadSeed(ctx context.Context, ns string, seed *apis.ObjectStoreSeed) (map[string][]byte, error) {
+	blobs := map[string][]byte{}
+
+	if seed.SecretRef != "" {
+		sec, err := o.ki.CoreV1().Secrets(ns).Get(ctx, seed.SecretRef, k8smeta.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seed secret %q: %w", seed.SecretRef, err)
+		}
+		for name, data := range sec.Data {
+			blobs[name] = data
+		}
+	}
+
+	if seed.ConfigMapRef != "" {
+		cm, err := o.ki.CoreV1().ConfigMaps(ns).Get(ctx, seed.ConfigMapRef, k8smeta.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seed configmap %q: %w", seed.ConfigMapRef, err)
+		}
+		for name, data := range cm.Data {
+			blobs[name] = []byte(data)
+		}
+		for name, data := range cm.BinaryData {
+			blobs[name] = data
+		}
+	}
+
+	return blobs, nil
+}
+
+// PutIfAbsent uploads data under name unless an object by that name already
+// exists, so re-seeding on every reconcile doesn't clobber operator edits.
+func (o *objectStoreConn) PutIfAbsent(ctx context.Context, bucket, name string, data []byte) error {
+	store, err := o.js.ObjectStore(bucket)
+	if err != nil {
+		return fmt.Errorf("failed to look up objectstore %q: %w", bucket, err)
+	}
+
+	if _, err := store.GetInfo(name); err == nil {
+		return nil
+	} else if err != nats.ErrObjectNotFound {
+		return fmt.Errorf("failed to check object %q: %w", name, err)
+	}
+
+	if _, err := store.Put(&nats.ObjectMeta{Name: name}, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to put object %q: %w", name, err)
+	}
+
+	return nil
+}
+
+func objectStoreConfig(os *apis.ObjectStore) *nats.ObjectStoreConfig {
+	spec := os.Spec
+
+	cfg := &nats.ObjectStoreConfig{
+		Bucket:      spec.BucketName,
+		Description: spec.Description,
+		TTL:         spec.TTL,
+		MaxBytes:    spec.MaxBytes,
+		Storage:     nats.StorageType(spec.Storage),
+		Replicas:    spec.Replicas,
+		Metadata:    spec.Metadata,
+	}
+
+	if spec.Placement != nil {
+		cfg.Placement = &nats.Placement{
+			Cluster: spec.Placement.Cluster,
+			Tags:    spec.Placement.Tags,
+		}
+	}
+
+	return cfg
+}
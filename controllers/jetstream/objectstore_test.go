@@ -0,0 +1,181 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apis "github.com/nats-io/nack/pkg/jetstream/apis/jetstream/v1"
+
+	k8sapi "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateObjectStoreUpdate(t *testing.T) {
+	base := apis.ObjectStoreSpec{
+		BucketName:  "avatars",
+		Description: "user avatars",
+		TTL:         "24h",
+		MaxBytes:    1 << 20,
+		Replicas:    3,
+		Storage:     "file",
+	}
+
+	cases := []struct {
+		name     string
+		prev     *apis.ObjectStore
+		next     *apis.ObjectStore
+		wantErr  bool
+		wantNoop bool
+	}{
+		{
+			name:     "no change: nothing to update",
+			prev:     &apis.ObjectStore{Spec: base},
+			next:     &apis.ObjectStore{Spec: base},
+			wantNoop: true,
+		},
+		{
+			name: "bucket name changed: rejected",
+			prev: &apis.ObjectStore{Spec: base},
+			next: &apis.ObjectStore{Spec: func() apis.ObjectStoreSpec {
+				s := base
+				s.BucketName = "avatars-v2"
+				return s
+			}()},
+			wantErr: true,
+		},
+		{
+			name: "storage changed: rejected",
+			prev: &apis.ObjectStore{Spec: base},
+			next: &apis.ObjectStore{Spec: func() apis.ObjectStoreSpec {
+				s := base
+				s.Storage = "memory"
+				return s
+			}()},
+			wantErr: true,
+		},
+		{
+			name: "description changed: allowed",
+			prev: &apis.ObjectStore{Spec: base},
+			next: &apis.ObjectStore{Spec: func() apis.ObjectStoreSpec {
+				s := base
+				s.Description = "renamed"
+				return s
+			}()},
+		},
+		{
+			name: "deletion timestamp set: allowed through regardless of other fields",
+			prev: &apis.ObjectStore{Spec: base},
+			next: &apis.ObjectStore{
+				ObjectMeta: k8smeta.ObjectMeta{DeletionTimestamp: &k8smeta.Time{}},
+				Spec: func() apis.ObjectStoreSpec {
+					s := base
+					s.BucketName = "avatars-v2"
+					return s
+				}(),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateObjectStoreUpdate(tc.prev, tc.next)
+			switch {
+			case tc.wantNoop:
+				if !errors.Is(err, errNothingToUpdate) {
+					t.Errorf("validateObjectStoreUpdate() = %v, want errNothingToUpdate", err)
+				}
+			case tc.wantErr:
+				if err == nil || errors.Is(err, errNothingToUpdate) {
+					t.Errorf("validateObjectStoreUpdate() = %v, want a rejection error", err)
+				}
+			default:
+				if err != nil {
+					t.Errorf("validateObjectStoreUpdate() = %v, want nil", err)
+				}
+			}
+		})
+	}
+}
+
+func TestEqualObjectStoreSpec(t *testing.T) {
+	a := apis.ObjectStoreSpec{BucketName: "avatars", TTL: "24h", MaxBytes: 1 << 20, Replicas: 3, Storage: "file"}
+
+	if !equalObjectStoreSpec(a, a) {
+		t.Error("equalObjectStoreSpec() = false for identical specs, want true")
+	}
+
+	b := a
+	b.Replicas = a.Replicas + 1
+	if equalObjectStoreSpec(a, b) {
+		t.Error("equalObjectStoreSpec() = true for specs differing in Replicas, want false")
+	}
+}
+
+func TestObjectStoreConnLoadSeed(t *testing.T) {
+	ki := fake.NewSimpleClientset(
+		&k8sapi.Secret{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "avatars-seed", Namespace: "default"},
+			Data: map[string][]byte{
+				"alice.png": []byte("alice-bytes"),
+			},
+		},
+		&k8sapi.ConfigMap{
+			ObjectMeta: k8smeta.ObjectMeta{Name: "avatars-seed-text", Namespace: "default"},
+			Data: map[string]string{
+				"readme.txt": "hello",
+			},
+			BinaryData: map[string][]byte{
+				"bob.png": []byte("bob-bytes"),
+			},
+		},
+	)
+
+	o := &objectStoreConn{ki: ki}
+
+	blobs, err := o.LoadSeed(context.Background(), "default", &apis.ObjectStoreSeed{
+		SecretRef:    "avatars-seed",
+		ConfigMapRef: "avatars-seed-text",
+	})
+	if err != nil {
+		t.Fatalf("LoadSeed() error = %v", err)
+	}
+
+	want := map[string]string{
+		"alice.png":  "alice-bytes",
+		"readme.txt": "hello",
+		"bob.png":    "bob-bytes",
+	}
+	if len(blobs) != len(want) {
+		t.Fatalf("LoadSeed() returned %d blobs, want %d", len(blobs), len(want))
+	}
+	for name, data := range want {
+		if got := string(blobs[name]); got != data {
+			t.Errorf("LoadSeed()[%q] = %q, want %q", name, got, data)
+		}
+	}
+}
+
+func TestObjectStoreConnLoadSeedMissingSecret(t *testing.T) {
+	ki := fake.NewSimpleClientset()
+	o := &objectStoreConn{ki: ki}
+
+	_, err := o.LoadSeed(context.Background(), "default", &apis.ObjectStoreSeed{SecretRef: "missing"})
+	if err == nil {
+		t.Fatal("LoadSeed() error = nil, want an error for a missing seed secret")
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright 2020 The NATS Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+
+	k8sapi "k8s.io/api/core/v1"
+	k8smeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// natsAuthRefs is the set of Secret references a Stream spec may carry to
+// authenticate against NATS, letting one operator manage CRs across many
+// accounts and clusters. KeyValueBucket and ObjectStore don't carry auth
+// refs: they connect to their own Spec.Servers with the controller's default
+// options and no Secret-based credentials; there is no Consumer
+// CRD/controller in this tree to extend, so Consumers aren't part of this
+// mechanism.
+type natsAuthRefs struct {
+	CredentialsSecret string
+	NKeySecret        string
+	JWTSecret         string
+	TLSSecret         string
+	Account           string
+}
+
+func (r natsAuthRefs) secretNames() []string {
+	var names []string
+	for _, name := range []string{r.CredentialsSecret, r.NKeySecret, r.JWTSecret, r.TLSSecret} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// cacheKey groups the refs that share one resolved+cached authMaterial.
+// It deliberately excludes resourceVersions: that's what decides whether the
+// cached entry is still fresh, not which entry to look up.
+func (r natsAuthRefs) cacheKey(ns string) string {
+	return ns + "|" + r.Account + "|" + r.CredentialsSecret + "|" + r.NKeySecret + "|" + r.JWTSecret + "|" + r.TLSSecret
+}
+
+// authMaterial is the resolved nats.Options for one set of natsAuthRefs, plus
+// enough bookkeeping to know when it's stale and what to clean up once it is.
+type authMaterial struct {
+	opts      []nats.Option
+	versions  map[string]string // secret name -> ResourceVersion, as of resolution
+	tempFiles []string
+}
+
+// identity is the connKey component for this material: it changes whenever
+// any referenced secret's ResourceVersion changes, so a credential rotation
+// forces natsConnPool to dial a fresh connection instead of reusing one
+// opened under the old credentials.
+func (m *authMaterial) identity(refs natsAuthRefs) string {
+	id := refs.Account
+	for _, name := range refs.secretNames() {
+		id += "|" + name + "@" + m.versions[name]
+	}
+	return id
+}
+
+func (m *authMaterial) removeTempFiles() {
+	for _, p := range m.tempFiles {
+		os.Remove(p)
+	}
+}
+
+// authCache resolves natsAuthRefs into authMaterial, reusing the previous
+// result (and its temp files) as long as none of the referenced secrets have
+// changed ResourceVersion. This keeps processStream's per-reconcile secret
+// lookups from writing a fresh plaintext creds/key temp file (and leaking
+// the old one) on every single reconcile, including periodic drift resyncs.
+type authCache struct {
+	mu      sync.Mutex
+	entries map[string]*authMaterial
+	// itemKeys tracks the cacheKey each CR (by its workqueue item key) last
+	// resolved against, and refCounts counts how many CRs currently depend on
+	// each cacheKey, so Resolve can detect when a CR's refs changed shape
+	// (e.g. CredentialsSecret now names a different Secret) and evict the
+	// old cacheKey's material once nothing else still depends on it, instead
+	// of leaving it (and its temp files) cached forever.
+	itemKeys  map[string]string
+	refCounts map[string]int
+}
+
+func newAuthCache() *authCache {
+	return &authCache{
+		entries:   make(map[string]*authMaterial),
+		itemKeys:  make(map[string]string),
+		refCounts: make(map[string]int),
+	}
+}
+
+// Resolve returns the nats.Options for refs, rebuilding (and replacing any
+// previously cached temp files) only when a referenced Secret has rotated.
+// itemKey is the CR's workqueue item key, used to evict the cacheKey it
+// previously depended on if refs now resolve to a different one.
+func (a *authCache) Resolve(ctx context.Context, ki kubernetes.Interface, ns string, refs natsAuthRefs, itemKey string) ([]nats.Option, error) {
+	if len(refs.secretNames()) == 0 {
+		a.mu.Lock()
+		a.releaseItemKeyLocked(itemKey)
+		a.mu.Unlock()
+		return nil, nil
+	}
+
+	secrets, versions, err := fetchAuthSecrets(ctx, ki, ns, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	key := refs.cacheKey(ns)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.trackItemKeyLocked(itemKey, key)
+
+	if cached, ok := a.entries[key]; ok && versionsEqual(cached.versions, versions) {
+		return cached.opts, nil
+	}
+
+	material, err := buildAuthMaterial(ns, refs, secrets)
+	if err != nil {
+		return nil, err
+	}
+	material.versions = versions
+
+	if cached, ok := a.entries[key]; ok {
+		cached.removeTempFiles()
+	}
+	a.entries[key] = material
+
+	return material.opts, nil
+}
+
+// trackItemKeyLocked records that itemKey now depends on cacheKey, evicting
+// itemKey's previous cacheKey once no other CR still references it. a.mu
+// must already be held.
+func (a *authCache) trackItemKeyLocked(itemKey, cacheKey string) {
+	if prev, ok := a.itemKeys[itemKey]; ok {
+		if prev == cacheKey {
+			return
+		}
+		a.releaseLocked(prev)
+	}
+
+	a.itemKeys[itemKey] = cacheKey
+	a.refCounts[cacheKey]++
+}
+
+// releaseItemKeyLocked drops itemKey's dependency entirely (it no longer
+// references any secret-based auth), evicting its old cacheKey if it was the
+// last dependent. a.mu must already be held.
+func (a *authCache) releaseItemKeyLocked(itemKey string) {
+	if prev, ok := a.itemKeys[itemKey]; ok {
+		delete(a.itemKeys, itemKey)
+		a.releaseLocked(prev)
+	}
+}
+
+// releaseLocked decrements cacheKey's refcount, evicting its cached material
+// and temp files once it reaches zero. a.mu must already be held.
+func (a *authCache) releaseLocked(cacheKey string) {
+	a.refCounts[cacheKey]--
+	if a.refCounts[cacheKey] > 0 {
+		return
+	}
+
+	delete(a.refCounts, cacheKey)
+	if cached, ok := a.entries[cacheKey]; ok {
+		cached.removeTempFiles()
+		delete(a.entries, cacheKey)
+	}
+}
+
+// Identity returns the connKey component for refs's currently cached
+// material, resolving it first if necessary.
+func (a *authCache) Identity(ctx context.Context, ki kubernetes.Interface, ns string, refs natsAuthRefs, itemKey string) (string, error) {
+	if len(refs.secretNames()) == 0 {
+		return refs.Account, nil
+	}
+
+	if _, err := a.Resolve(ctx, ki, ns, refs, itemKey); err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.entries[refs.cacheKey(ns)].identity(refs), nil
+}
+
+func versionsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, v := range a {
+		if b[name] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func fetchAuthSecrets(ctx context.Context, ki kubernetes.Interface, ns string, refs natsAuthRefs) (map[string]*k8sapi.Secret, map[string]string, error) {
+	secrets := make(map[string]*k8sapi.Secret)
+	versions := make(map[string]string)
+
+	for _, name := range refs.secretNames() {
+		if _, ok := secrets[name]; ok {
+			continue
+		}
+
+		sec, err := ki.CoreV1().Secrets(ns).Get(ctx, name, k8smeta.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read secret %q: %w", name, err)
+		}
+
+		secrets[name] = sec
+		versions[name] = sec.ResourceVersion
+	}
+
+	return secrets, versions, nil
+}
+
+// buildAuthMaterial turns already-fetched Secrets into nats.Options.
+// CredentialsSecret and NKeySecret/TLSSecret go through nats.go APIs that
+// only accept file paths, so their contents are written to temp files owned
+// by the returned authMaterial. JWTSecret is resolved entirely in memory via
+// nats.UserJWTAndSeed, since a bare JWT (unlike a CredentialsSecret's
+// chained creds file) has no file-based nats.go option.
+func buildAuthMaterial(ns string, refs natsAuthRefs, secrets map[string]*k8sapi.Secret) (*authMaterial, error) {
+	m := &authMaterial{}
+
+	if refs.CredentialsSecret != "" {
+		data, err := secretKey(secrets, refs.CredentialsSecret, "nats.creds")
+		if err != nil {
+			return nil, err
+		}
+		path, err := m.writeTempFile(ns, refs.CredentialsSecret, data)
+		if err != nil {
+			return nil, err
+		}
+		m.opts = append(m.opts, nats.UserCredentials(path))
+	}
+
+	if refs.JWTSecret != "" {
+		jwt, err := secretKey(secrets, refs.JWTSecret, "jwt")
+		if err != nil {
+			return nil, err
+		}
+		seed, err := secretKey(secrets, refs.JWTSecret, "seed")
+		if err != nil {
+			return nil, err
+		}
+		m.opts = append(m.opts, nats.UserJWTAndSeed(string(jwt), string(seed)))
+	}
+
+	if refs.NKeySecret != "" {
+		data, err := secretKey(secrets, refs.NKeySecret, "seed")
+		if err != nil {
+			return nil, err
+		}
+		path, err := m.writeTempFile(ns, refs.NKeySecret, data)
+		if err != nil {
+			return nil, err
+		}
+		opt, err := nats.NkeyOptionFromSeed(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load nkey seed from secret %q: %w", refs.NKeySecret, err)
+		}
+		m.opts = append(m.opts, opt)
+	}
+
+	if refs.TLSSecret != "" {
+		certData, err := secretKey(secrets, refs.TLSSecret, k8sapi.TLSCertKey)
+		if err != nil {
+			return nil, err
+		}
+		keyData, err := secretKey(secrets, refs.TLSSecret, k8sapi.TLSPrivateKeyKey)
+		if err != nil {
+			return nil, err
+		}
+		certPath, err := m.writeTempFile(ns, refs.TLSSecret, certData)
+		if err != nil {
+			return nil, err
+		}
+		keyPath, err := m.writeTempFile(ns, refs.TLSSecret+"-key", keyData)
+		if err != nil {
+			return nil, err
+		}
+		m.opts = append(m.opts, nats.ClientCert(certPath, keyPath))
+	}
+
+	return m, nil
+}
+
+func secretKey(secrets map[string]*k8sapi.Secret, name, key string) ([]byte, error) {
+	sec, ok := secrets[name]
+	if !ok {
+		return nil, fmt.Errorf("secret %q was not fetched", name)
+	}
+
+	data, ok := sec.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %q has no key %q", name, key)
+	}
+
+	return data, nil
+}
+
+func (m *authMaterial) writeTempFile(ns, name string, data []byte) (string, error) {
+	f, err := os.CreateTemp("", fmt.Sprintf("nack-%s-%s-*", ns, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file for secret %q: %w", name, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return "", fmt.Errorf("failed to write temp file for secret %q: %w", name, err)
+	}
+
+	m.tempFiles = append(m.tempFiles, f.Name())
+	return f.Name(), nil
+}
+
+// secretIndex tracks which CR workqueue keys depend on which Secret, so a
+// Secret rotation (key renewal, cert renewal) re-enqueues every owning CR
+// instead of waiting for the next natural resync.
+type secretIndex struct {
+	mu   sync.Mutex
+	deps map[string][]connDependent
+}
+
+func newSecretIndex() *secretIndex {
+	return &secretIndex{deps: make(map[string][]connDependent)}
+}
+
+func (s *secretIndex) Register(ns, name string, q workqueue.RateLimitingInterface, itemKey string) {
+	key := ns + "/" + name
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.deps[key] {
+		if d.queue == q && d.key == itemKey {
+			return
+		}
+	}
+	s.deps[key] = append(s.deps[key], connDependent{queue: q, key: itemKey})
+}
+
+func (s *secretIndex) requeueDependents(ns, name string) {
+	key := ns + "/" + name
+
+	s.mu.Lock()
+	deps := append([]connDependent(nil), s.deps[key]...)
+	s.mu.Unlock()
+
+	for _, d := range deps {
+		d.queue.Add(d.key)
+	}
+}
+
+func secretEventHandlers(index *secretIndex) cache.ResourceEventHandlerFuncs {
+	requeue := func(obj interface{}) {
+		sec, ok := obj.(*k8sapi.Secret)
+		if !ok {
+			return
+		}
+
+		index.requeueDependents(sec.Namespace, sec.Name)
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: requeue,
+		UpdateFunc: func(_, next interface{}) {
+			requeue(next)
+		},
+		DeleteFunc: requeue,
+	}
+}
+
+func registerAuthSecrets(index *secretIndex, ns string, refs natsAuthRefs, q workqueue.RateLimitingInterface, itemKey string) {
+	for _, name := range refs.secretNames() {
+		index.Register(ns, name, q, itemKey)
+	}
+}
@@ -92,15 +92,24 @@ func streamEventHandlers(ctx context.Context, q workqueue.RateLimitingInterface,
 }
 
 func enqueueStreamWork(q workqueue.RateLimitingInterface, stream *apis.Stream) (err error) {
-	key, err := cache.MetaNamespaceKeyFunc(stream)
+	key, err := enqueueStreamWorkKey(stream)
 	if err != nil {
-		return fmt.Errorf("failed to queue stream work: %w", err)
+		return err
 	}
 
 	q.Add(key)
 	return nil
 }
 
+func enqueueStreamWorkKey(stream *apis.Stream) (string, error) {
+	key, err := cache.MetaNamespaceKeyFunc(stream)
+	if err != nil {
+		return "", fmt.Errorf("failed to queue stream work: %w", err)
+	}
+
+	return key, nil
+}
+
 func validateStreamUpdate(prev, next *apis.Stream) (err error) {
 	defer func() {
 		if err != nil {
@@ -112,11 +121,12 @@ func validateStreamUpdate(prev, next *apis.Stream) (err error) {
 		return nil
 	}
 
-	if prev.Spec.Name != next.Spec.Name {
-		return fmt.Errorf("updating stream name is not allowed, please recreate")
+	migrating := next.Spec.MigrationStrategy == apis.MigrationStrategyMirror
+	if prev.Spec.Name != next.Spec.Name && !migrating {
+		return fmt.Errorf("updating stream name is not allowed, please recreate (or set migrationStrategy: Mirror)")
 	}
-	if prev.Spec.Storage != next.Spec.Storage {
-		return fmt.Errorf("updating stream storage is not allowed, please recreate")
+	if prev.Spec.Storage != next.Spec.Storage && !migrating {
+		return fmt.Errorf("updating stream storage is not allowed, please recreate (or set migrationStrategy: Mirror)")
 	}
 
 	if equality.Semantic.DeepEqual(prev.Spec, next.Spec) {
@@ -147,6 +157,12 @@ func (c *Controller) processNextQueueItem() {
 		return
 	}
 
+	if c.ha.Sharded && !ownsShard(c.ha.Identity, c.shardReplicas(), ns+"/"+name) {
+		// A different replica owns this CR; drop it without requeueing.
+		c.streamQueue.Forget(item)
+		return
+	}
+
 	err = c.processStream(ns, name)
 	if err == nil {
 		// Item processed successfully, don't requeue.
@@ -178,6 +194,16 @@ func getNATSOptions(connName string) []nats.Option {
 	}
 }
 
+func streamAuthRefs(stream *apis.Stream) natsAuthRefs {
+	return natsAuthRefs{
+		CredentialsSecret: stream.Spec.CredentialsSecret,
+		NKeySecret:        stream.Spec.NKeySecret,
+		JWTSecret:         stream.Spec.JWTSecret,
+		TLSSecret:         stream.Spec.TLSSecret,
+		Account:           stream.Spec.Account,
+	}
+}
+
 func (c *Controller) processStream(ns, name string) (err error) {
 	defer func() {
 		if err != nil {
@@ -194,14 +220,55 @@ func (c *Controller) processStream(ns, name string) (err error) {
 
 	sif := c.ji.Streams(stream.Namespace)
 
-	err = c.sc.Connect(strings.Join(stream.Spec.Servers, ","), getNATSOptions(c.natsName)...)
+	key, err := enqueueStreamWorkKey(stream)
+	if err != nil {
+		return err
+	}
+
+	refs := streamAuthRefs(stream)
+	authOpts, err := c.authCache.Resolve(c.ctx, c.ki, stream.Namespace, refs, key)
+	if err != nil {
+		if _, serr := setStreamErrored(c.ctx, stream, sif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+	registerAuthSecrets(c.secretIndex, stream.Namespace, refs, c.streamQueue, key)
+
+	authIdentity, err := c.authCache.Identity(c.ctx, c.ki, stream.Namespace, refs, key)
+	if err != nil {
+		if _, serr := setStreamErrored(c.ctx, stream, sif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+
+	connName := c.natsName
+	servers := stream.Spec.Servers
+	if len(servers) == 0 {
+		servers = c.natsServers
+	}
+
+	connKey := connKey(servers, authIdentity)
+	nc, err := c.connPool.Get(connKey, strings.Join(servers, ","), append(getNATSOptions(connName), authOpts...)...)
 	if err != nil {
 		if _, serr := setStreamErrored(c.ctx, stream, sif, err); serr != nil {
 			return fmt.Errorf("%s: %w", err, serr)
 		}
 		return err
 	}
-	defer c.sc.Close()
+	c.connPool.Register(connKey, c.streamQueue, key)
+
+	if err := c.sc.UseConn(nc); err != nil {
+		if _, serr := setStreamErrored(c.ctx, stream, sif, err); serr != nil {
+			return fmt.Errorf("%s: %w", err, serr)
+		}
+		return err
+	}
+
+	if stream.GetDeletionTimestamp() == nil && streamNeedsMigration(stream) {
+		return c.reconcileStreamMigration(stream, sif)
+	}
 
 	deleteOK := stream.GetDeletionTimestamp() != nil
 	newGeneration := stream.Generation != stream.Status.ObservedGeneration
@@ -281,6 +348,10 @@ func (c *Controller) processStream(ns, name string) (err error) {
 		return nil
 	}
 
+	if streamExists && !deleteOK && !newGeneration {
+		return c.checkStreamDrift(stream, sif)
+	}
+
 	// default: Nothing to do.
 	return nil
 }
@@ -315,6 +386,9 @@ func setStreamSynced(ctx context.Context, s *apis.Stream, i typed.StreamInterfac
 	sc := s.DeepCopy()
 
 	sc.Status.ObservedGeneration = s.Generation
+	sc.Status.LastSyncTime = time.Now().UTC().Format(time.RFC3339Nano)
+	sc.Status.LastAppliedName = s.Spec.Name
+	sc.Status.LastAppliedStorage = s.Spec.Storage
 	sc.Status.Conditions = upsertStreamCondition(sc.Status.Conditions, apis.StreamCondition{
 		Type:               streamReadyCondType,
 		Status:             k8sapi.ConditionTrue,
@@ -405,4 +479,4 @@ func clearStreamFinalizer(ctx context.Context, s *apis.Stream, sif typed.StreamI
 	}
 
 	return res, nil
-}
\ No newline at end of file
+}